@@ -0,0 +1,322 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+const (
+	headerLocation = "Location"
+
+	// defaultPollingDelay is used to poll for an Azure long-running operation if
+	// the operation does not return a Retry-After header and the caller does not
+	// specify a delay of its own.
+	defaultPollingDelay = 30 * time.Second
+)
+
+// Statuses of an Azure long-running operation. Succeeded, Failed and Canceled are
+// terminal; anything else is considered in-progress.
+const (
+	operationInProgress = "InProgress"
+	operationSucceeded  = "Succeeded"
+	operationFailed     = "Failed"
+	operationCanceled   = "Canceled"
+)
+
+// operationResource is the body returned when polling the URL given by the
+// Azure-AsyncOperation header.
+type operationResource struct {
+	Status string        `json:"status"`
+	Error  *ServiceError `json:"error"`
+}
+
+// provisioningStateResource is the body returned when polling the URL given by the
+// Location header, or when reading provisioningState out of the original resource.
+type provisioningStateResource struct {
+	Properties struct {
+		ProvisioningState string `json:"provisioningState"`
+	} `json:"properties"`
+	Status string        `json:"status"`
+	Error  *ServiceError `json:"error"`
+}
+
+func (psr provisioningStateResource) state() string {
+	if psr.Status != "" {
+		return psr.Status
+	}
+	return psr.Properties.ProvisioningState
+}
+
+// statusCodesForMethod returns the HTTP status codes that ARM considers a candidate
+// for long-running operation polling when returned in response to the given HTTP
+// method.
+func statusCodesForMethod(method string) []int {
+	switch method {
+	case http.MethodPut, http.MethodPatch:
+		return []int{http.StatusOK, http.StatusCreated, http.StatusAccepted}
+	case http.MethodDelete, http.MethodPost:
+		return []int{http.StatusOK, http.StatusAccepted, http.StatusNoContent}
+	default:
+		return []int{http.StatusOK, http.StatusAccepted}
+	}
+}
+
+// ResponseIsLongRunning returns true if the passed response requires polling, taking
+// into account the Azure-AsyncOperation and Location patterns, a provisioningState in the
+// response body, and the HTTP verb that produced the response.
+func ResponseIsLongRunning(resp *http.Response) bool {
+	if resp.Request == nil || !autorest.ResponseHasStatusCode(resp, statusCodesForMethod(resp.Request.Method)...) {
+		return false
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		return true
+	}
+	if GetAsyncOperation(resp) != "" || resp.Header.Get(http.CanonicalHeaderKey(headerLocation)) != "" {
+		return true
+	}
+
+	// Neither async header is present; a PUT/PATCH that completed synchronously can still
+	// be a long-running operation if the resource body carries a non-terminal
+	// provisioningState.
+	status, _, err := bodyPollingStatus(resp)
+	if err != nil {
+		return false
+	}
+	return status != operationSucceeded && status != operationFailed && status != operationCanceled
+}
+
+// Future tracks the status and result of an Azure long-running operation. It is
+// obtained by calling NewFutureFromResponse on the response to the request that
+// started the operation. Future.Done polls the operation once; WaitForCompletion
+// polls until a terminal state is reached or the passed context is canceled.
+type Future struct {
+	req           *http.Request
+	pollingMethod string
+	pollingURL    string
+	resp          *http.Response
+	status        string
+	err           *ServiceError
+}
+
+// NewFutureFromResponse returns a Future that tracks the long-running operation
+// started by the request underlying resp. It inspects resp for the Azure-AsyncOperation
+// and Location headers (and, failing that, a provisioningState in the response body) to
+// determine how the operation should be polled.
+func NewFutureFromResponse(resp *http.Response) (Future, error) {
+	method := ""
+	if resp.Request != nil {
+		method = resp.Request.Method
+	}
+	if !autorest.ResponseHasStatusCode(resp, statusCodesForMethod(method)...) {
+		return Future{}, autorest.NewErrorWithResponse("azure", "NewFutureFromResponse", resp, "unexpected status code for a long-running operation response")
+	}
+
+	f := Future{req: resp.Request, resp: resp}
+
+	if asyncURL := GetAsyncOperation(resp); asyncURL != "" {
+		f.pollingMethod = "AsyncOperation"
+		f.pollingURL = asyncURL
+		f.status = operationInProgress
+		return f, nil
+	}
+	if locationURL := resp.Header.Get(http.CanonicalHeaderKey(headerLocation)); locationURL != "" {
+		f.pollingMethod = "Location"
+		f.pollingURL = locationURL
+		f.status = operationInProgress
+		return f, nil
+	}
+
+	// Neither async header is present; the only remaining ARM pattern is a
+	// provisioningState embedded in the resource body (DELETE's 204, or a PUT
+	// that completed synchronously).
+	f.pollingMethod = "Body"
+	if resp.Request != nil && resp.Request.URL != nil {
+		f.pollingURL = resp.Request.URL.String()
+	}
+	status, se, err := bodyPollingStatus(resp)
+	if err != nil {
+		return Future{}, err
+	}
+	f.status = status
+	f.err = se
+	return f, nil
+}
+
+// Done returns true if the long-running operation has reached a terminal state. If
+// the operation is still in progress it issues a single poll against the tracked
+// polling URL and updates the Future's state from the response. It never sleeps;
+// callers that want to poll until completion should use WaitForCompletion.
+func (f *Future) Done(sender autorest.Sender) (bool, error) {
+	if f.hasTerminated() {
+		return true, f.terminalError()
+	}
+
+	req, err := autorest.Prepare(&http.Request{}, autorest.AsGet(), autorest.WithBaseURL(f.pollingURL))
+	if err != nil {
+		return false, autorest.NewErrorWithError(err, "azure", "Future.Done", nil, "failure creating poll request to %s", f.pollingURL)
+	}
+	if f.req != nil {
+		req.Header = cloneHeader(f.req.Header)
+	}
+
+	resp, err := sender.Do(req)
+	if err != nil {
+		return false, err
+	}
+	autorest.Respond(f.resp, autorest.ByClosing())
+	f.resp = resp
+
+	if loc := resp.Header.Get(http.CanonicalHeaderKey(headerLocation)); loc != "" {
+		f.pollingURL = loc
+	}
+
+	var status string
+	var se *ServiceError
+	if f.pollingMethod == "AsyncOperation" {
+		status, se, err = asyncOperationStatus(resp)
+	} else {
+		status, se, err = bodyPollingStatus(resp)
+	}
+	if err != nil {
+		return false, err
+	}
+	f.status = status
+	f.err = se
+
+	return f.hasTerminated(), f.terminalError()
+}
+
+// WaitForCompletion polls the long-running operation until it reaches a terminal
+// state or ctx is done, sleeping between polls for the duration given by the
+// Retry-After header of the last response (or a default delay if none was sent).
+func (f *Future) WaitForCompletion(ctx context.Context, client autorest.Client) error {
+	for {
+		done, err := f.Done(client)
+		if err != nil || done {
+			return err
+		}
+		delay := autorest.GetPollingDelay(f.resp, defaultPollingDelay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Response returns the last response received while polling the operation.
+func (f *Future) Response() *http.Response {
+	return f.resp
+}
+
+// Status returns the last known status of the operation. Before the first poll this
+// is the status NewFutureFromResponse derived from the initial response.
+func (f *Future) Status() string {
+	return f.status
+}
+
+func (f *Future) hasTerminated() bool {
+	switch f.status {
+	case operationSucceeded, operationFailed, operationCanceled:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *Future) terminalError() error {
+	if f.status != operationFailed && f.status != operationCanceled {
+		return nil
+	}
+	e := RequestError{ServiceError: f.err}
+	if e.ServiceError == nil {
+		e.ServiceError = &ServiceError{Code: f.status, Message: "the long-running operation has terminated unsuccessfully"}
+	}
+	e.DetailedError = autorest.DetailedError{
+		Original:    fmt.Errorf("autorest/azure: long-running operation %s", f.status),
+		PackageType: "azure",
+		Method:      "Future.Done",
+		StatusCode:  f.resp.StatusCode,
+		Message:     fmt.Sprintf("Long-running operation terminated with status %q", f.status),
+	}
+	return &e
+}
+
+func asyncOperationStatus(resp *http.Response) (string, *ServiceError, error) {
+	var or operationResource
+	b, decodeErr := autorest.CopyAndDecode(autorest.EncodedAsJSON, resp.Body, &or)
+	resp.Body = ioutil.NopCloser(&b)
+	if decodeErr != nil {
+		return "", nil, fmt.Errorf("autorest/azure: could not decode operation resource: %q error: %v", b.String(), decodeErr)
+	}
+	if or.Status == "" {
+		return "", nil, fmt.Errorf("autorest/azure: invalid operation resource: %q", b.String())
+	}
+	return or.Status, or.Error, nil
+}
+
+// bodyPollingStatus determines the operation status from a response that carries the
+// resource itself, either because it is the response to a Location poll or because the
+// original request completed synchronously. A 204 always means the operation succeeded;
+// otherwise the status is read from a provisioningState in the body, defaulting to
+// Succeeded if the body has none (a GET of the final resource does not always echo it).
+// An empty or undecodable body counts as Succeeded for a 200 or 201 and as InProgress
+// for any other status code. The returned ServiceError, if any, is the error embedded in that body.
+func bodyPollingStatus(resp *http.Response) (string, *ServiceError, error) {
+	if resp.StatusCode == http.StatusNoContent {
+		return operationSucceeded, nil, nil
+	}
+
+	var psr provisioningStateResource
+	b, decodeErr := autorest.CopyAndDecode(autorest.EncodedAsJSON, resp.Body, &psr)
+	resp.Body = ioutil.NopCloser(&b)
+	if decodeErr != nil || b.Len() == 0 {
+		if autorest.ResponseHasStatusCode(resp, http.StatusOK, http.StatusCreated) {
+			return operationSucceeded, nil, nil
+		}
+		return operationInProgress, nil, nil
+	}
+
+	state := psr.state()
+	if state == "" {
+		return operationSucceeded, psr.Error, nil
+	}
+	return state, psr.Error, nil
+}
+
+// WithAsyncPolling will poll until the completion of an Azure long-running operation,
+// recognizing the Azure-AsyncOperation header, the Location header, and a
+// provisioningState in the response body. The delay between requests is taken from the
+// HTTP Retry-After header, if present, or the passed delay otherwise. Polling may be
+// canceled by signaling on the optional http.Request channel.
+func WithAsyncPolling(defaultDelay time.Duration) autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := s.Do(r)
+			if err != nil || !ResponseIsLongRunning(resp) {
+				return resp, err
+			}
+
+			future, err := NewFutureFromResponse(resp)
+			if err != nil {
+				return resp, err
+			}
+
+			for {
+				done, err := future.Done(s)
+				if err != nil || done {
+					return future.resp, err
+				}
+				delay := autorest.GetPollingDelay(future.resp, defaultDelay)
+				if err := autorest.DelayForBackoff(delay, 1, r.Cancel); err != nil {
+					return future.resp, err
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package azure
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newResponse(method string, statusCode int, header http.Header, body string) *http.Response {
+	req, _ := http.NewRequest(method, "https://management.azure.com/resource", nil)
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode: statusCode,
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
+
+func TestResponseIsLongRunning(t *testing.T) {
+	cases := []struct {
+		name  string
+		resp  *http.Response
+		isLRO bool
+	}{
+		{"put accepted", newResponse(http.MethodPut, http.StatusAccepted, nil, ""), true},
+		{"put with location header", newResponse(http.MethodPut, http.StatusOK, http.Header{"Location": {"https://management.azure.com/poll"}}, ""), true},
+		{"put synchronous success", newResponse(http.MethodPut, http.StatusOK, nil, `{"properties":{"provisioningState":"Succeeded"}}`), false},
+		{"put synchronous in progress body", newResponse(http.MethodPut, http.StatusOK, nil, `{"properties":{"provisioningState":"InProgress"}}`), true},
+		{"delete no content", newResponse(http.MethodDelete, http.StatusNoContent, nil, ""), false},
+		{"unexpected status code", newResponse(http.MethodPut, http.StatusNotFound, nil, ""), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := ResponseIsLongRunning(c.resp); got != c.isLRO {
+				t.Errorf("ResponseIsLongRunning() = %v, want %v", got, c.isLRO)
+			}
+		})
+	}
+}
+
+// TestFutureDoneCarriesOriginalHeaders verifies that poll requests issued by Future.Done
+// carry the Authorization header of the request that started the operation; otherwise
+// every poll against a real ARM endpoint comes back 401.
+func TestFutureDoneCarriesOriginalHeaders(t *testing.T) {
+	const wantAuth = "Bearer original-token"
+
+	var polls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != wantAuth {
+			t.Errorf("poll request missing original Authorization header: got %q want %q", got, wantAuth)
+		}
+		status := operationInProgress
+		if atomic.AddInt32(&polls, 1) > 1 {
+			status = operationSucceeded
+		}
+		json.NewEncoder(w).Encode(operationResource{Status: status})
+	}))
+	defer srv.Close()
+
+	origReq, _ := http.NewRequest(http.MethodPut, "https://management.azure.com/resource", nil)
+	origReq.Header.Set("Authorization", wantAuth)
+
+	header := http.Header{}
+	header.Set("Azure-AsyncOperation", srv.URL)
+	resp := &http.Response{
+		StatusCode: http.StatusCreated,
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    origReq,
+	}
+
+	future, err := NewFutureFromResponse(resp)
+	if err != nil {
+		t.Fatalf("NewFutureFromResponse: %v", err)
+	}
+	if future.pollingMethod != "AsyncOperation" {
+		t.Fatalf("pollingMethod = %q, want AsyncOperation", future.pollingMethod)
+	}
+
+	done, err := future.Done(srv.Client())
+	if err != nil {
+		t.Fatalf("Done (1st poll): %v", err)
+	}
+	if done {
+		t.Fatal("Done reported complete after the first (InProgress) poll")
+	}
+
+	done, err = future.Done(srv.Client())
+	if err != nil {
+		t.Fatalf("Done (2nd poll): %v", err)
+	}
+	if !done {
+		t.Fatal("Done did not report complete after a Succeeded poll")
+	}
+}
+
+func TestFutureBodyPollingPattern(t *testing.T) {
+	var polls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := "InProgress"
+		if atomic.AddInt32(&polls, 1) > 1 {
+			state = "Succeeded"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"properties": map[string]string{"provisioningState": state},
+		})
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL, nil)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"properties":{"provisioningState":"InProgress"}}`)),
+		Request:    req,
+	}
+
+	future, err := NewFutureFromResponse(resp)
+	if err != nil {
+		t.Fatalf("NewFutureFromResponse: %v", err)
+	}
+	if future.pollingMethod != "Body" {
+		t.Fatalf("pollingMethod = %q, want Body", future.pollingMethod)
+	}
+	if future.Status() != operationInProgress {
+		t.Fatalf("Status() = %q, want %q", future.Status(), operationInProgress)
+	}
+
+	done, err := future.Done(srv.Client())
+	if err != nil || done {
+		t.Fatalf("Done (1st poll): done=%v err=%v, want done=false err=nil", done, err)
+	}
+	done, err = future.Done(srv.Client())
+	if err != nil || !done {
+		t.Fatalf("Done (2nd poll): done=%v err=%v, want done=true err=nil", done, err)
+	}
+}
+
+func TestFutureTerminalError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(operationResource{
+			Status: operationFailed,
+			Error:  &ServiceError{Code: "BadRequest", Message: "deployment failed"},
+		})
+	}))
+	defer srv.Close()
+
+	origReq, _ := http.NewRequest(http.MethodPut, "https://management.azure.com/resource", nil)
+	header := http.Header{}
+	header.Set("Azure-AsyncOperation", srv.URL)
+	resp := &http.Response{
+		StatusCode: http.StatusCreated,
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    origReq,
+	}
+
+	future, err := NewFutureFromResponse(resp)
+	if err != nil {
+		t.Fatalf("NewFutureFromResponse: %v", err)
+	}
+
+	done, err := future.Done(srv.Client())
+	if !done {
+		t.Fatalf("Done did not report terminal state for a Failed operation")
+	}
+	if err == nil {
+		t.Fatal("Done returned a nil error for a Failed operation")
+	}
+	re, ok := err.(*RequestError)
+	if !ok {
+		t.Fatalf("error is %T, want *RequestError", err)
+	}
+	if re.ServiceError.Code != "BadRequest" {
+		t.Errorf("ServiceError.Code = %q, want BadRequest", re.ServiceError.Code)
+	}
+}
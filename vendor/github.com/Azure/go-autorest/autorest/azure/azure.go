@@ -7,10 +7,8 @@ package azure
 
 import (
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/Azure/go-autorest/autorest"
 )
@@ -34,8 +32,9 @@ const (
 
 // ServiceError encapsulates the error response from an Azure service.
 type ServiceError struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code    string                   `json:"code"`
+	Message string                   `json:"message"`
+	Details []map[string]interface{} `json:"details"`
 }
 
 // RequestError describes an error response returned by Azure service.
@@ -136,52 +135,6 @@ func GetAsyncOperation(resp *http.Response) string {
 	return resp.Header.Get(http.CanonicalHeaderKey(HeaderAsyncOperation))
 }
 
-// ResponseIsLongRunning returns true if the passed response is for an Azure long-running operation.
-func ResponseIsLongRunning(resp *http.Response) bool {
-	return autorest.ResponseRequiresPolling(resp, http.StatusCreated) && GetAsyncOperation(resp) != ""
-}
-
-// NewAsyncPollingRequest allocates and returns a new http.Request to poll an Azure long-running
-// operation. If it successfully creates the request, it will also close the body of the passed
-// response, otherwise the body remains open.
-func NewAsyncPollingRequest(resp *http.Response, c autorest.Client) (*http.Request, error) {
-	location := GetAsyncOperation(resp)
-	if location == "" {
-		return nil, autorest.NewErrorWithResponse("azure", "NewAsyncPollingRequest", resp, "Azure-AsyncOperation header missing from response that requires polling")
-	}
-
-	req, err := autorest.Prepare(&http.Request{},
-		autorest.AsGet(),
-		autorest.WithBaseURL(location))
-	if err != nil {
-		return nil, autorest.NewErrorWithError(err, "azure", "NewAsyncPollingRequest", nil, "Failure creating poll request to %s", location)
-	}
-
-	autorest.Respond(resp,
-		c.ByInspecting(),
-		autorest.ByClosing())
-
-	return req, nil
-}
-
-// WithAsyncPolling will poll until the completion of an Azure long-running operation. The delay
-// time between requests is taken from the HTTP Retry-After header, if present, or the passed
-// delay otherwise. Polling may be canceled by signaling on the optional http.Request channel.
-func WithAsyncPolling(defaultDelay time.Duration) autorest.SendDecorator {
-	return func(s autorest.Sender) autorest.Sender {
-		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
-			resp, err := s.Do(r)
-			for err == nil && ResponseIsLongRunning(resp) {
-				err = autorest.DelayForBackoff(autorest.GetPollingDelay(resp, defaultDelay), 1, r.Cancel)
-				if err == nil {
-					resp, err = s.Do(r)
-				}
-			}
-			return resp, err
-		})
-	}
-}
-
 // WithErrorUnlessStatusCode returns a RespondDecorator that emits an
 // azure.RequestError by reading the response body unless the response HTTP status code
 // is among the set passed.
@@ -194,24 +147,5 @@ func WithAsyncPolling(defaultDelay time.Duration) autorest.SendDecorator {
 // If this Responder returns an error, the response body will be replaced with
 // an in-memory reader, which needs no further closing.
 func WithErrorUnlessStatusCode(codes ...int) autorest.RespondDecorator {
-	return func(r autorest.Responder) autorest.Responder {
-		return autorest.ResponderFunc(func(resp *http.Response) error {
-			err := r.Respond(resp)
-			if err == nil && !autorest.ResponseHasStatusCode(resp, codes...) {
-				var e RequestError
-				defer resp.Body.Close()
-
-				b, decodeErr := autorest.CopyAndDecode(autorest.EncodedAsJSON, resp.Body, &e)
-				resp.Body = ioutil.NopCloser(&b) // replace body with in-memory reader
-				if decodeErr != nil || e.ServiceError == nil {
-					return fmt.Errorf("autorest/azure: error response cannot be parsed: %q error: %v", b.String(), err)
-				}
-
-				e.RequestID = ExtractRequestID(resp)
-				e.StatusCode = resp.StatusCode
-				err = &e
-			}
-			return err
-		})
-	}
+	return withErrorUnlessStatusCode(codes, decodeARMError)
 }
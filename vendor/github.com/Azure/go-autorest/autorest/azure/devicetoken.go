@@ -0,0 +1,159 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+const deviceCodeGrantType = "device_code"
+
+var (
+	// ErrDeviceCodeExpired is returned by WaitForUserCompletion when the user did not
+	// complete the device flow before the code expired.
+	ErrDeviceCodeExpired = fmt.Errorf("autorest/azure: device code expired")
+
+	// ErrDeviceAccessDenied is returned by WaitForUserCompletion when the user declined
+	// the sign-in prompt.
+	ErrDeviceAccessDenied = fmt.Errorf("autorest/azure: device flow access denied by user")
+)
+
+// DeviceCode carries the instructions and code InitiateDeviceAuth obtained for the user,
+// along with what WaitForUserCompletion needs to poll for a token once they comply.
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       string `json:"expires_in"`
+	Interval        string `json:"interval"`
+	Message         string `json:"message"`
+
+	ClientID    string      `json:"-"`
+	Resource    string      `json:"-"`
+	OAuthConfig OAuthConfig `json:"-"`
+}
+
+// InitiateDeviceAuth begins a device-code authorization flow for clientID against
+// resource, per https://tools.ietf.org/html/rfc8628. Show the returned DeviceCode's
+// Message to the user, then pass it to WaitForUserCompletion to obtain a Token once they
+// comply.
+func InitiateDeviceAuth(sender autorest.Sender, oauthConfig OAuthConfig, clientID, resource string) (*DeviceCode, error) {
+	v := url.Values{
+		"client_id": {clientID},
+		"resource":  {resource},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, oauthConfig.DeviceCodeEndpoint.String(), strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("autorest/azure: failed to build device code request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := sender.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("autorest/azure: failed to initiate device auth: %v", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("autorest/azure: failed to read device auth response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("autorest/azure: device auth request failed with status %d: %s", resp.StatusCode, string(b))
+	}
+
+	var code DeviceCode
+	if err := json.Unmarshal(b, &code); err != nil {
+		return nil, fmt.Errorf("autorest/azure: failed to decode device auth response: %q error: %v", string(b), err)
+	}
+	code.ClientID = clientID
+	code.Resource = resource
+	code.OAuthConfig = oauthConfig
+
+	return &code, nil
+}
+
+// WaitForUserCompletion polls the token endpoint named by deviceCode.OAuthConfig until the
+// user completes the device flow identified by deviceCode, returning the acquired Token.
+// It honors the authorization_pending, slow_down (which increases the poll interval),
+// expired_token, and access_denied responses defined by RFC 8628.
+func WaitForUserCompletion(sender autorest.Sender, deviceCode *DeviceCode) (*Token, error) {
+	intervalSeconds, err := strconv.Atoi(deviceCode.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("autorest/azure: invalid device code interval %q: %v", deviceCode.Interval, err)
+	}
+	expiresInSeconds, err := strconv.Atoi(deviceCode.ExpiresIn)
+	if err != nil {
+		return nil, fmt.Errorf("autorest/azure: invalid device code expires_in %q: %v", deviceCode.ExpiresIn, err)
+	}
+
+	interval := time.Duration(intervalSeconds) * time.Second
+	deadline := time.Now().Add(time.Duration(expiresInSeconds) * time.Second)
+
+	v := url.Values{
+		"client_id":  {deviceCode.ClientID},
+		"resource":   {deviceCode.Resource},
+		"grant_type": {deviceCodeGrantType},
+		"code":       {deviceCode.DeviceCode},
+	}
+	body := v.Encode()
+
+	for {
+		if time.Now().After(deadline) {
+			return nil, ErrDeviceCodeExpired
+		}
+
+		req, err := http.NewRequest(http.MethodPost, deviceCode.OAuthConfig.TokenEndpoint.String(), strings.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("autorest/azure: failed to build device token request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := sender.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("autorest/azure: failed to poll for device token: %v", err)
+		}
+		b, readErr := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if readErr != nil {
+			return nil, fmt.Errorf("autorest/azure: failed to read device token response: %v", readErr)
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			var token Token
+			if err := json.Unmarshal(b, &token); err != nil {
+				return nil, fmt.Errorf("autorest/azure: failed to decode device token response: %q error: %v", string(b), err)
+			}
+			return &token, nil
+		}
+
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(b, &errResp); err != nil {
+			return nil, fmt.Errorf("autorest/azure: device token poll failed with status %d: %s", resp.StatusCode, string(b))
+		}
+
+		switch errResp.Error {
+		case "authorization_pending":
+			time.Sleep(interval)
+		case "slow_down":
+			interval += 5 * time.Second
+			time.Sleep(interval)
+		case "expired_token", "code_expired":
+			return nil, ErrDeviceCodeExpired
+		case "access_denied":
+			return nil, ErrDeviceAccessDenied
+		default:
+			return nil, fmt.Errorf("autorest/azure: device token poll failed: %s", errResp.Error)
+		}
+	}
+}
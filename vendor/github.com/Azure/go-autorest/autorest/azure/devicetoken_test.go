@@ -0,0 +1,155 @@
+package azure
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func newDeviceOAuthConfig(t *testing.T, srv *httptest.Server) OAuthConfig {
+	t.Helper()
+	cfg, err := NewOAuthConfig(srv.URL, "tenant1")
+	if err != nil {
+		t.Fatalf("NewOAuthConfig: %v", err)
+	}
+	return *cfg
+}
+
+func TestInitiateDeviceAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("ParseForm: %v", err)
+		}
+		if got := r.Form.Get("client_id"); got != "client1" {
+			t.Errorf("client_id = %q, want client1", got)
+		}
+		if got := r.Form.Get("resource"); got != "https://management.azure.com/" {
+			t.Errorf("resource = %q, want https://management.azure.com/", got)
+		}
+		fmt.Fprint(w, `{
+			"device_code": "dc1",
+			"user_code": "ABCD-EFGH",
+			"verification_url": "https://microsoft.com/devicelogin",
+			"expires_in": "900",
+			"interval": "0",
+			"message": "To sign in, use a web browser..."
+		}`)
+	}))
+	defer srv.Close()
+
+	cfg := newDeviceOAuthConfig(t, srv)
+	code, err := InitiateDeviceAuth(srv.Client(), cfg, "client1", "https://management.azure.com/")
+	if err != nil {
+		t.Fatalf("InitiateDeviceAuth: %v", err)
+	}
+	if code.UserCode != "ABCD-EFGH" || code.DeviceCode != "dc1" {
+		t.Errorf("code = %+v, want UserCode=ABCD-EFGH DeviceCode=dc1", code)
+	}
+	if code.ClientID != "client1" || code.Resource != "https://management.azure.com/" {
+		t.Errorf("code did not retain ClientID/Resource: %+v", code)
+	}
+}
+
+func TestInitiateDeviceAuthErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_client"}`)
+	}))
+	defer srv.Close()
+
+	cfg := newDeviceOAuthConfig(t, srv)
+	if _, err := InitiateDeviceAuth(srv.Client(), cfg, "client1", "resource"); err == nil {
+		t.Fatal("InitiateDeviceAuth returned no error for a non-200 response")
+	}
+}
+
+// TestWaitForUserCompletionPending exercises RFC 8628's authorization_pending state
+// before the user completes the flow, then a successful token response.
+func TestWaitForUserCompletionPending(t *testing.T) {
+	var polls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&polls, 1) == 1 {
+			fmt.Fprint(w, `{"error":"authorization_pending"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"at1","token_type":"Bearer","expires_in":"3600"}`)
+	}))
+	defer srv.Close()
+
+	cfg := newDeviceOAuthConfig(t, srv)
+	deviceCode := &DeviceCode{
+		DeviceCode:  "dc1",
+		Interval:    "0",
+		ExpiresIn:   "900",
+		ClientID:    "client1",
+		Resource:    "resource",
+		OAuthConfig: cfg,
+	}
+
+	token, err := WaitForUserCompletion(srv.Client(), deviceCode)
+	if err != nil {
+		t.Fatalf("WaitForUserCompletion: %v", err)
+	}
+	if token.AccessToken != "at1" {
+		t.Errorf("AccessToken = %q, want at1", token.AccessToken)
+	}
+	if got := atomic.LoadInt32(&polls); got != 2 {
+		t.Errorf("server was polled %d times, want 2 (pending + success)", got)
+	}
+}
+
+func TestWaitForUserCompletionExpired(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"error":"expired_token"}`)
+	}))
+	defer srv.Close()
+
+	cfg := newDeviceOAuthConfig(t, srv)
+	deviceCode := &DeviceCode{
+		DeviceCode:  "dc1",
+		Interval:    "0",
+		ExpiresIn:   "900",
+		OAuthConfig: cfg,
+	}
+
+	if _, err := WaitForUserCompletion(srv.Client(), deviceCode); err != ErrDeviceCodeExpired {
+		t.Errorf("err = %v, want ErrDeviceCodeExpired", err)
+	}
+}
+
+func TestWaitForUserCompletionAccessDenied(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"error":"access_denied"}`)
+	}))
+	defer srv.Close()
+
+	cfg := newDeviceOAuthConfig(t, srv)
+	deviceCode := &DeviceCode{
+		DeviceCode:  "dc1",
+		Interval:    "0",
+		ExpiresIn:   "900",
+		OAuthConfig: cfg,
+	}
+
+	if _, err := WaitForUserCompletion(srv.Client(), deviceCode); err != ErrDeviceAccessDenied {
+		t.Errorf("err = %v, want ErrDeviceAccessDenied", err)
+	}
+}
+
+func TestWaitForUserCompletionAlreadyExpiredDeadline(t *testing.T) {
+	deviceCode := &DeviceCode{
+		DeviceCode: "dc1",
+		Interval:   "0",
+		ExpiresIn:  "-1",
+		OAuthConfig: OAuthConfig{
+			TokenEndpoint: url.URL{Scheme: "https", Host: "login.microsoftonline.com", Path: "/tenant1/oauth2/token"},
+		},
+	}
+
+	if _, err := WaitForUserCompletion(http.DefaultClient, deviceCode); err != ErrDeviceCodeExpired {
+		t.Errorf("err = %v, want ErrDeviceCodeExpired", err)
+	}
+}
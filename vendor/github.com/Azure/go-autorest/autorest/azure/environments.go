@@ -0,0 +1,231 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Environment represents a set of endpoints for each of Azure's Clouds. The fields are
+// the endpoints for the public Azure cloud, a sovereign cloud (US government, China or
+// Germany), or
+// a private Azure Stack deployment, the latter discovered at runtime via
+// EnvironmentFromURL.
+type Environment struct {
+	Name                             string `json:"name"`
+	ManagementPortalURL              string `json:"managementPortalURL"`
+	PublishSettingsURL               string `json:"publishSettingsURL"`
+	ServiceManagementEndpoint        string `json:"serviceManagementEndpoint"`
+	ResourceManagerEndpoint          string `json:"resourceManagerEndpoint"`
+	ActiveDirectoryEndpoint          string `json:"activeDirectoryEndpoint"`
+	GalleryEndpoint                  string `json:"galleryEndpoint"`
+	KeyVaultEndpoint                 string `json:"keyVaultEndpoint"`
+	GraphEndpoint                    string `json:"graphEndpoint"`
+	StorageEndpointSuffix            string `json:"storageEndpointSuffix"`
+	SQLDatabaseDNSSuffix             string `json:"sqlDatabaseDNSSuffix"`
+	TrafficManagerDNSSuffix          string `json:"trafficManagerDNSSuffix"`
+	KeyVaultDNSSuffix                string `json:"keyVaultDNSSuffix"`
+	ServiceBusEndpointSuffix         string `json:"serviceBusEndpointSuffix"`
+	ServiceManagementVMDNSSuffix     string `json:"serviceManagementVMDNSSuffix"`
+	ResourceManagerVMDNSSuffix       string `json:"resourceManagerVMDNSSuffix"`
+	ContainerRegistryDNSSuffix       string `json:"containerRegistryDNSSuffix"`
+	TokenAudience                    string `json:"tokenAudience"`
+	ServiceManagementVMTokenAudience string `json:"serviceManagementVMTokenAudience"`
+	KeyVaultTokenAudience            string `json:"keyVaultTokenAudience"`
+	GraphTokenAudience               string `json:"graphTokenAudience"`
+}
+
+var (
+	// PublicCloud is the default public Azure cloud Environment.
+	PublicCloud = Environment{
+		Name:                             "AzurePublicCloud",
+		ManagementPortalURL:              "https://manage.windowsazure.com/",
+		PublishSettingsURL:               "https://manage.windowsazure.com/publishsettings/index",
+		ServiceManagementEndpoint:        "https://management.core.windows.net/",
+		ResourceManagerEndpoint:          "https://management.azure.com/",
+		ActiveDirectoryEndpoint:          "https://login.microsoftonline.com/",
+		GalleryEndpoint:                  "https://gallery.azure.com/",
+		KeyVaultEndpoint:                 "https://vault.azure.net/",
+		GraphEndpoint:                    "https://graph.windows.net/",
+		StorageEndpointSuffix:            "core.windows.net",
+		SQLDatabaseDNSSuffix:             "database.windows.net",
+		TrafficManagerDNSSuffix:          "trafficmanager.net",
+		KeyVaultDNSSuffix:                "vault.azure.net",
+		ServiceBusEndpointSuffix:         "servicebus.azure.com",
+		ServiceManagementVMDNSSuffix:     "cloudapp.net",
+		ResourceManagerVMDNSSuffix:       "cloudapp.azure.com",
+		ContainerRegistryDNSSuffix:       "azurecr.io",
+		TokenAudience:                    "https://management.azure.com/",
+		ServiceManagementVMTokenAudience: "https://management.core.windows.net/",
+		KeyVaultTokenAudience:            "https://vault.azure.net",
+		GraphTokenAudience:               "https://graph.windows.net/",
+	}
+
+	// USGovernmentCloud is the Azure Government cloud Environment.
+	USGovernmentCloud = Environment{
+		Name:                             "AzureUSGovernmentCloud",
+		ManagementPortalURL:              "https://manage.windowsazure.us/",
+		PublishSettingsURL:               "https://manage.windowsazure.us/publishsettings/index",
+		ServiceManagementEndpoint:        "https://management.core.usgovcloudapi.net/",
+		ResourceManagerEndpoint:          "https://management.usgovcloudapi.net/",
+		ActiveDirectoryEndpoint:          "https://login.microsoftonline.us/",
+		GalleryEndpoint:                  "https://gallery.usgovcloudapi.net/",
+		KeyVaultEndpoint:                 "https://vault.usgovcloudapi.net/",
+		GraphEndpoint:                    "https://graph.windows.net/",
+		StorageEndpointSuffix:            "core.usgovcloudapi.net",
+		SQLDatabaseDNSSuffix:             "database.usgovcloudapi.net",
+		TrafficManagerDNSSuffix:          "usgovtrafficmanager.net",
+		KeyVaultDNSSuffix:                "vault.usgovcloudapi.net",
+		ServiceBusEndpointSuffix:         "servicebus.usgovcloudapi.net",
+		ServiceManagementVMDNSSuffix:     "usgovcloudapp.net",
+		ResourceManagerVMDNSSuffix:       "cloudapp.usgovcloudapi.net",
+		ContainerRegistryDNSSuffix:       "azurecr.us",
+		TokenAudience:                    "https://management.usgovcloudapi.net/",
+		ServiceManagementVMTokenAudience: "https://management.core.usgovcloudapi.net/",
+		KeyVaultTokenAudience:            "https://vault.usgovcloudapi.net",
+		GraphTokenAudience:               "https://graph.windows.net/",
+	}
+
+	// ChinaCloud is the Azure China cloud Environment, operated by 21Vianet.
+	ChinaCloud = Environment{
+		Name:                             "AzureChinaCloud",
+		ManagementPortalURL:              "https://manage.chinacloudapi.com/",
+		PublishSettingsURL:               "https://manage.chinacloudapi.com/publishsettings/index",
+		ServiceManagementEndpoint:        "https://management.core.chinacloudapi.cn/",
+		ResourceManagerEndpoint:          "https://management.chinacloudapi.cn/",
+		ActiveDirectoryEndpoint:          "https://login.chinacloudapi.cn/",
+		GalleryEndpoint:                  "https://gallery.chinacloudapi.cn/",
+		KeyVaultEndpoint:                 "https://vault.azure.cn/",
+		GraphEndpoint:                    "https://graph.chinacloudapi.cn/",
+		StorageEndpointSuffix:            "core.chinacloudapi.cn",
+		SQLDatabaseDNSSuffix:             "database.chinacloudapi.cn",
+		TrafficManagerDNSSuffix:          "trafficmanager.cn",
+		KeyVaultDNSSuffix:                "vault.azure.cn",
+		ServiceBusEndpointSuffix:         "servicebus.chinacloudapi.cn",
+		ServiceManagementVMDNSSuffix:     "chinacloudapp.cn",
+		ResourceManagerVMDNSSuffix:       "cloudapp.chinacloudapi.cn",
+		ContainerRegistryDNSSuffix:       "azurecr.cn",
+		TokenAudience:                    "https://management.chinacloudapi.cn/",
+		ServiceManagementVMTokenAudience: "https://management.core.chinacloudapi.cn/",
+		KeyVaultTokenAudience:            "https://vault.azure.cn",
+		GraphTokenAudience:               "https://graph.chinacloudapi.cn/",
+	}
+
+	// GermanCloud is the Azure Germany cloud Environment, operated by T-Systems.
+	GermanCloud = Environment{
+		Name:                             "AzureGermanCloud",
+		ManagementPortalURL:              "https://portal.microsoftazure.de/",
+		PublishSettingsURL:               "https://manage.microsoftazure.de/publishsettings/index",
+		ServiceManagementEndpoint:        "https://management.core.cloudapi.de/",
+		ResourceManagerEndpoint:          "https://management.microsoftazure.de/",
+		ActiveDirectoryEndpoint:          "https://login.microsoftonline.de/",
+		GalleryEndpoint:                  "https://gallery.cloudapi.de/",
+		KeyVaultEndpoint:                 "https://vault.microsoftazure.de/",
+		GraphEndpoint:                    "https://graph.cloudapi.de/",
+		StorageEndpointSuffix:            "core.cloudapi.de",
+		SQLDatabaseDNSSuffix:             "database.cloudapi.de",
+		TrafficManagerDNSSuffix:          "azuretrafficmanager.de",
+		KeyVaultDNSSuffix:                "vault.microsoftazure.de",
+		ServiceBusEndpointSuffix:         "servicebus.cloudapi.de",
+		ServiceManagementVMDNSSuffix:     "azurecloudapp.de",
+		ResourceManagerVMDNSSuffix:       "cloudapp.microsoftazure.de",
+		ContainerRegistryDNSSuffix:       "azurecr.de",
+		TokenAudience:                    "https://management.microsoftazure.de/",
+		ServiceManagementVMTokenAudience: "https://management.core.cloudapi.de/",
+		KeyVaultTokenAudience:            "https://vault.microsoftazure.de",
+		GraphTokenAudience:               "https://graph.cloudapi.de/",
+	}
+
+	environments = map[string]Environment{
+		"AZUREPUBLICCLOUD":       PublicCloud,
+		"AZUREUSGOVERNMENTCLOUD": USGovernmentCloud,
+		"AZURECHINACLOUD":        ChinaCloud,
+		"AZUREGERMANCLOUD":       GermanCloud,
+	}
+)
+
+// EnvironmentFromName returns an Environment for the named cloud, matched
+// case-insensitively against "AzurePublicCloud", "AzureUSGovernmentCloud",
+// "AzureChinaCloud", and "AzureGermanCloud".
+func EnvironmentFromName(name string) (Environment, error) {
+	name = strings.ToUpper(name)
+	env, ok := environments[name]
+	if !ok {
+		return env, fmt.Errorf("autorest/azure: there is no cloud environment matching the name %q", name)
+	}
+	return env, nil
+}
+
+// azureStackMetadata is the shape returned by an Azure Resource Manager's
+// /metadata/endpoints discovery endpoint: the AD login endpoint and token audiences are
+// nested under "authentication" rather than being top-level keys, and the DNS suffixes
+// used to build per-service URLs (storage, key vault, SQL, container registry) are
+// nested under "suffixes".
+type azureStackMetadata struct {
+	GalleryEndpoint string `json:"galleryEndpoint"`
+	GraphEndpoint   string `json:"graphEndpoint"`
+	PortalEndpoint  string `json:"portalEndpoint"`
+	Authentication  struct {
+		LoginEndpoint string   `json:"loginEndpoint"`
+		Audiences     []string `json:"audiences"`
+	} `json:"authentication"`
+	Suffixes struct {
+		Storage           string `json:"storage"`
+		KeyVaultDNS       string `json:"keyVaultDns"`
+		SQLServerHostname string `json:"sqlServerHostname"`
+		ACRLoginServer    string `json:"acrLoginServer"`
+	} `json:"suffixes"`
+}
+
+// EnvironmentFromURL fetches the metadata endpoint exposed by an Azure Resource Manager
+// at armEndpoint (e.g. an Azure Stack deployment) and builds the Environment it describes.
+// This is how Azure Stack instances self-describe; armEndpoint should be the base ARM URL
+// with no trailing slash.
+func EnvironmentFromURL(armEndpoint string) (Environment, error) {
+	var env Environment
+
+	metadataURL := strings.TrimSuffix(armEndpoint, "/") + "/metadata/endpoints?api-version=2015-01-01"
+
+	resp, err := http.Get(metadataURL)
+	if err != nil {
+		return env, fmt.Errorf("autorest/azure: failed to fetch metadata endpoints from %q: %v", metadataURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return env, fmt.Errorf("autorest/azure: unexpected status code %d fetching metadata endpoints from %q", resp.StatusCode, metadataURL)
+	}
+
+	var md azureStackMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&md); err != nil {
+		return env, fmt.Errorf("autorest/azure: failed to decode metadata endpoints from %q: %v", metadataURL, err)
+	}
+
+	env = Environment{
+		Name:                       "AzureStackCloud",
+		ResourceManagerEndpoint:    armEndpoint,
+		ServiceManagementEndpoint:  armEndpoint,
+		ActiveDirectoryEndpoint:    md.Authentication.LoginEndpoint,
+		GalleryEndpoint:            md.GalleryEndpoint,
+		GraphEndpoint:              md.GraphEndpoint,
+		ManagementPortalURL:        md.PortalEndpoint,
+		TokenAudience:              armEndpoint,
+		StorageEndpointSuffix:      md.Suffixes.Storage,
+		SQLDatabaseDNSSuffix:       md.Suffixes.SQLServerHostname,
+		ContainerRegistryDNSSuffix: md.Suffixes.ACRLoginServer,
+	}
+	if len(md.Authentication.Audiences) > 0 {
+		env.TokenAudience = md.Authentication.Audiences[0]
+	}
+	if md.Suffixes.KeyVaultDNS != "" {
+		env.KeyVaultDNSSuffix = md.Suffixes.KeyVaultDNS
+		env.KeyVaultEndpoint = fmt.Sprintf("https://%s/", md.Suffixes.KeyVaultDNS)
+	}
+
+	if env.ActiveDirectoryEndpoint == "" || env.ResourceManagerEndpoint == "" {
+		return Environment{}, fmt.Errorf("autorest/azure: metadata endpoints from %q did not describe an Active Directory login endpoint", metadataURL)
+	}
+
+	return env, nil
+}
@@ -0,0 +1,106 @@
+package azure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEnvironmentFromName(t *testing.T) {
+	cases := []struct {
+		name string
+		want Environment
+	}{
+		{"AzurePublicCloud", PublicCloud},
+		{"azurepubliccloud", PublicCloud},
+		{"AzureUSGovernmentCloud", USGovernmentCloud},
+		{"AzureChinaCloud", ChinaCloud},
+		{"AzureGermanCloud", GermanCloud},
+	}
+	for _, c := range cases {
+		got, err := EnvironmentFromName(c.name)
+		if err != nil {
+			t.Errorf("EnvironmentFromName(%q): %v", c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("EnvironmentFromName(%q) = %+v, want %+v", c.name, got, c.want)
+		}
+	}
+
+	if _, err := EnvironmentFromName("NotACloud"); err == nil {
+		t.Error("EnvironmentFromName(\"NotACloud\") returned no error, want one")
+	}
+}
+
+func TestEnvironmentFromURL(t *testing.T) {
+	const metadataBody = `{
+		"galleryEndpoint": "https://gallery.azurestack.local/",
+		"graphEndpoint": "https://graph.azurestack.local/",
+		"portalEndpoint": "https://portal.azurestack.local/",
+		"authentication": {
+			"loginEndpoint": "https://login.azurestack.local/adfs",
+			"audiences": ["https://management.azurestack.local/"]
+		},
+		"suffixes": {
+			"storage": "blob.azurestack.local",
+			"keyVaultDns": "vault.azurestack.local",
+			"sqlServerHostname": "database.azurestack.local",
+			"acrLoginServer": "azurecr.azurestack.local"
+		}
+	}`
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metadata/endpoints" {
+			t.Errorf("request path = %q, want /metadata/endpoints", r.URL.Path)
+		}
+		w.Write([]byte(metadataBody))
+	}))
+	defer srv.Close()
+
+	env, err := EnvironmentFromURL(srv.URL)
+	if err != nil {
+		t.Fatalf("EnvironmentFromURL: %v", err)
+	}
+
+	want := Environment{
+		Name:                       "AzureStackCloud",
+		ResourceManagerEndpoint:    srv.URL,
+		ServiceManagementEndpoint:  srv.URL,
+		ActiveDirectoryEndpoint:    "https://login.azurestack.local/adfs",
+		GalleryEndpoint:            "https://gallery.azurestack.local/",
+		GraphEndpoint:              "https://graph.azurestack.local/",
+		ManagementPortalURL:        "https://portal.azurestack.local/",
+		TokenAudience:              "https://management.azurestack.local/",
+		StorageEndpointSuffix:      "blob.azurestack.local",
+		SQLDatabaseDNSSuffix:       "database.azurestack.local",
+		ContainerRegistryDNSSuffix: "azurecr.azurestack.local",
+		KeyVaultDNSSuffix:          "vault.azurestack.local",
+		KeyVaultEndpoint:           "https://vault.azurestack.local/",
+	}
+	if env != want {
+		t.Errorf("EnvironmentFromURL() = %+v, want %+v", env, want)
+	}
+}
+
+func TestEnvironmentFromURLMissingLoginEndpoint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"galleryEndpoint": "https://gallery.azurestack.local/"}`))
+	}))
+	defer srv.Close()
+
+	if _, err := EnvironmentFromURL(srv.URL); err == nil {
+		t.Error("EnvironmentFromURL with no authentication.loginEndpoint returned no error, want one")
+	}
+}
+
+func TestEnvironmentFromURLUnexpectedStatusCode(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	if _, err := EnvironmentFromURL(srv.URL); err == nil {
+		t.Error("EnvironmentFromURL with a 404 metadata response returned no error, want one")
+	}
+}
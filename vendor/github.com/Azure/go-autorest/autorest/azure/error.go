@@ -0,0 +1,101 @@
+package azure
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// withErrorUnlessStatusCode is shared by WithErrorUnlessStatusCode and
+// WithOdataErrorUnlessStatusCode. decode is given the raw response body and should
+// return the ServiceError it found, or an error if the body didn't match the shape it
+// knows how to parse.
+func withErrorUnlessStatusCode(codes []int, decode func([]byte) (*ServiceError, error)) autorest.RespondDecorator {
+	return func(r autorest.Responder) autorest.Responder {
+		return autorest.ResponderFunc(func(resp *http.Response) error {
+			err := r.Respond(resp)
+			if err == nil && !autorest.ResponseHasStatusCode(resp, codes...) {
+				defer resp.Body.Close()
+
+				b, readErr := ioutil.ReadAll(resp.Body)
+				resp.Body = ioutil.NopCloser(bytes.NewReader(b)) // replace body with in-memory reader
+				if readErr != nil {
+					return fmt.Errorf("autorest/azure: error response cannot be read: %v", readErr)
+				}
+
+				se, decodeErr := decode(b)
+				if decodeErr != nil || se == nil {
+					return fmt.Errorf("autorest/azure: error response cannot be parsed: %q error: %v", string(b), decodeErr)
+				}
+
+				e := RequestError{ServiceError: se}
+				e.RequestID = ExtractRequestID(resp)
+				e.StatusCode = resp.StatusCode
+				err = &e
+			}
+			return err
+		})
+	}
+}
+
+// decodeARMError decodes the ARM {"error":{"code","message","details"}} error shape.
+func decodeARMError(b []byte) (*ServiceError, error) {
+	var e RequestError
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+	if e.ServiceError == nil {
+		return nil, fmt.Errorf("autorest/azure: response is not an ARM error")
+	}
+	return e.ServiceError, nil
+}
+
+// odataError is the shape returned by Azure AD and Microsoft Graph endpoints:
+// {"odata.error": {"code": {"value": ...}, "message": {"value": ...}}}.
+type odataError struct {
+	Odata struct {
+		Code struct {
+			Value string `json:"value"`
+		} `json:"code"`
+		Message struct {
+			Value string `json:"value"`
+		} `json:"message"`
+	} `json:"odata.error"`
+}
+
+func decodeOdataError(b []byte) (*ServiceError, error) {
+	var oe odataError
+	if err := json.Unmarshal(b, &oe); err != nil {
+		return nil, err
+	}
+	if oe.Odata.Code.Value == "" && oe.Odata.Message.Value == "" {
+		return nil, fmt.Errorf("autorest/azure: response is not an odata.error")
+	}
+	return &ServiceError{Code: oe.Odata.Code.Value, Message: oe.Odata.Message.Value}, nil
+}
+
+// WithOdataErrorUnlessStatusCode returns a RespondDecorator that emits an
+// azure.RequestError by reading the response body unless the response HTTP status code
+// is among the set passed. It understands both the OData error shape returned by Azure
+// AD and Microsoft Graph ({"odata.error": {"code": {"value": ...}, "message": {"value":
+// ...}}}) and the ARM error shape, trying the former first, so callers talking to either
+// kind of endpoint don't need to branch on which shape to expect.
+//
+// If there is a chance the service may return a body matching neither shape, a decoding
+// error will be returned containing the response body. In any case, the Responder will
+// return an error if the status code is not satisfied.
+//
+// If this Responder returns an error, the response body will be replaced with an
+// in-memory reader, which needs no further closing.
+func WithOdataErrorUnlessStatusCode(codes ...int) autorest.RespondDecorator {
+	return withErrorUnlessStatusCode(codes, func(b []byte) (*ServiceError, error) {
+		if se, err := decodeOdataError(b); err == nil {
+			return se, nil
+		}
+		return decodeARMError(b)
+	})
+}
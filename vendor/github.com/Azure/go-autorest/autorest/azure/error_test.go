@@ -0,0 +1,96 @@
+package azure
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func passthroughResponder() autorest.Responder {
+	return autorest.ResponderFunc(func(resp *http.Response) error { return nil })
+}
+
+func errorResponse(statusCode int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: statusCode,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestWithErrorUnlessStatusCodeARMBody(t *testing.T) {
+	resp := errorResponse(http.StatusBadRequest, `{"error":{"code":"InvalidParameter","message":"bad value"}}`)
+	resp.Header.Set("x-ms-request-id", "req-1")
+
+	err := WithErrorUnlessStatusCode(http.StatusOK)(passthroughResponder()).Respond(resp)
+	if err == nil {
+		t.Fatal("expected an error for a non-matching status code")
+	}
+	re, ok := err.(*RequestError)
+	if !ok {
+		t.Fatalf("error is %T, want *RequestError", err)
+	}
+	if re.ServiceError.Code != "InvalidParameter" || re.ServiceError.Message != "bad value" {
+		t.Errorf("ServiceError = %+v, want Code=InvalidParameter Message=%q", re.ServiceError, "bad value")
+	}
+	if re.RequestID != "req-1" {
+		t.Errorf("RequestID = %q, want req-1", re.RequestID)
+	}
+}
+
+func TestWithErrorUnlessStatusCodePassesMatchingStatus(t *testing.T) {
+	resp := errorResponse(http.StatusOK, "")
+	if err := WithErrorUnlessStatusCode(http.StatusOK)(passthroughResponder()).Respond(resp); err != nil {
+		t.Fatalf("unexpected error for a matching status code: %v", err)
+	}
+}
+
+// TestWithErrorUnlessStatusCodeUnparseableBodyReportsDecodeError guards against
+// regressing to reporting the decode failure as "<nil>": the decorator must surface the
+// actual reason the body failed to parse as an ARM error.
+func TestWithErrorUnlessStatusCodeUnparseableBodyReportsDecodeError(t *testing.T) {
+	resp := errorResponse(http.StatusBadRequest, `not json at all`)
+
+	err := WithErrorUnlessStatusCode(http.StatusOK)(passthroughResponder()).Respond(resp)
+	if err == nil {
+		t.Fatal("expected an error for an unparseable body")
+	}
+	if strings.Contains(err.Error(), "error: <nil>") {
+		t.Fatalf("error hides the decode failure: %v", err)
+	}
+}
+
+func TestWithOdataErrorUnlessStatusCodeOdataBody(t *testing.T) {
+	resp := errorResponse(http.StatusForbidden, `{"odata.error":{"code":{"value":"Authorization_RequestDenied"},"message":{"value":"Insufficient privileges"}}}`)
+
+	err := WithOdataErrorUnlessStatusCode(http.StatusOK)(passthroughResponder()).Respond(resp)
+	if err == nil {
+		t.Fatal("expected an error for a non-matching status code")
+	}
+	re, ok := err.(*RequestError)
+	if !ok {
+		t.Fatalf("error is %T, want *RequestError", err)
+	}
+	if re.ServiceError.Code != "Authorization_RequestDenied" {
+		t.Errorf("ServiceError.Code = %q, want Authorization_RequestDenied", re.ServiceError.Code)
+	}
+}
+
+func TestWithOdataErrorUnlessStatusCodeFallsBackToARMBody(t *testing.T) {
+	resp := errorResponse(http.StatusBadRequest, `{"error":{"code":"InvalidParameter","message":"bad value"}}`)
+
+	err := WithOdataErrorUnlessStatusCode(http.StatusOK)(passthroughResponder()).Respond(resp)
+	if err == nil {
+		t.Fatal("expected an error for a non-matching status code")
+	}
+	re, ok := err.(*RequestError)
+	if !ok {
+		t.Fatalf("error is %T, want *RequestError", err)
+	}
+	if re.ServiceError.Code != "InvalidParameter" {
+		t.Errorf("ServiceError.Code = %q, want InvalidParameter", re.ServiceError.Code)
+	}
+}
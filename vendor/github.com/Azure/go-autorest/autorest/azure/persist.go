@@ -0,0 +1,48 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// SaveToken persists token to path with the given file permissions, creating the
+// containing directory if necessary. It is intended for CLI-style tools that cache a
+// ServicePrincipalToken's refresh token between runs; LoadToken restores what it writes.
+func SaveToken(path string, mode os.FileMode, token Token) error {
+	dir := filepath.Dir(path)
+	// Directories need the execute bit to be traversable; add it wherever mode grants
+	// read, so a caller-supplied 0600 yields a 0700 directory rather than the previous
+	// hardcoded world-writable os.ModePerm.
+	dirMode := mode | (mode&0444)>>2
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return fmt.Errorf("autorest/azure: failed to create directory %q to store token: %v", dir, err)
+	}
+
+	b, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("autorest/azure: failed to marshal token: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, b, mode); err != nil {
+		return fmt.Errorf("autorest/azure: failed to write token to %q: %v", path, err)
+	}
+	// WriteFile only applies mode when creating the file, so chmod in case path already existed.
+	return os.Chmod(path, mode)
+}
+
+// LoadToken restores a Token previously written by SaveToken.
+func LoadToken(path string) (*Token, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("autorest/azure: failed to read token from %q: %v", path, err)
+	}
+
+	var token Token
+	if err := json.Unmarshal(b, &token); err != nil {
+		return nil, fmt.Errorf("autorest/azure: failed to unmarshal token from %q: %v", path, err)
+	}
+	return &token, nil
+}
@@ -0,0 +1,67 @@
+package azure
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSaveAndLoadToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azure-persist-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "nested", "token.json")
+	want := Token{AccessToken: "at1", RefreshToken: "rt1", ExpiresOn: "1700000000"}
+
+	if err := SaveToken(path, 0600, want); err != nil {
+		t.Fatalf("SaveToken: %v", err)
+	}
+
+	got, err := LoadToken(path)
+	if err != nil {
+		t.Fatalf("LoadToken: %v", err)
+	}
+	if *got != want {
+		t.Errorf("LoadToken() = %+v, want %+v", *got, want)
+	}
+}
+
+// TestSaveTokenDirectoryModeNotWorldWritable guards against regressing to a hardcoded
+// os.ModePerm (0777) directory regardless of the file mode the caller asked for.
+func TestSaveTokenDirectoryModeNotWorldWritable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits aren't meaningful on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "azure-persist-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	subdir := filepath.Join(dir, "secrets")
+	path := filepath.Join(subdir, "token.json")
+
+	if err := SaveToken(path, 0600, Token{AccessToken: "at1"}); err != nil {
+		t.Fatalf("SaveToken: %v", err)
+	}
+
+	info, err := os.Stat(subdir)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode&0077 != 0 {
+		t.Errorf("directory mode = %04o, want no group/other permissions (caller requested file mode 0600)", mode)
+	}
+}
+
+func TestLoadTokenMissingFile(t *testing.T) {
+	if _, err := LoadToken(filepath.Join(os.TempDir(), "does-not-exist-token.json")); err == nil {
+		t.Error("LoadToken for a missing file returned no error, want one")
+	}
+}
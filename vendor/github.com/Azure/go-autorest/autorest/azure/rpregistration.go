@@ -0,0 +1,198 @@
+package azure
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+const (
+	missingSubscriptionRegistrationCode = "MissingSubscriptionRegistration"
+
+	// defaultRegistrationPollingTimeout bounds how long DoRetryWithRegistration will wait
+	// for a resource provider to finish registering before giving up, if the client does
+	// not specify its own PollingDuration.
+	defaultRegistrationPollingTimeout = 120 * time.Second
+
+	defaultRegistrationPollingDelay = 5 * time.Second
+
+	providerAPIVersion = "2016-02-01"
+)
+
+var (
+	subscriptionIDRE    = regexp.MustCompile(`(?i)/subscriptions/([^/]+)/`)
+	providerNamespaceRE = regexp.MustCompile(`(?i)namespace '([^']+)'`)
+)
+
+// resourceProviderNamespace extracts the unregistered provider namespace from a
+// MissingSubscriptionRegistration error, preferring the structured details Azure
+// includes over parsing the human-readable message.
+func resourceProviderNamespace(se *ServiceError) string {
+	for _, d := range se.Details {
+		if ns, ok := d["target"].(string); ok && ns != "" {
+			return ns
+		}
+	}
+	if m := providerNamespaceRE.FindStringSubmatch(se.Message); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// DoRetryWithRegistration returns a SendDecorator that, upon receiving a 409 response
+// with a MissingSubscriptionRegistration service error, registers the offending resource
+// provider namespace with the subscription and resends the original request once the
+// registration completes. This composes with WithAsyncPolling: it runs as an outer
+// decorator, re-issuing the whole (possibly polling) send on registration.
+func DoRetryWithRegistration(client autorest.Client) autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			// r.GetBody lets us reset the body for the retry without ever reading it
+			// ourselves; only buffer it into memory by hand when that isn't available.
+			var bodyBytes []byte
+			if r.Body != nil && r.GetBody == nil {
+				b, err := ioutil.ReadAll(r.Body)
+				r.Body.Close()
+				if err != nil {
+					return nil, err
+				}
+				bodyBytes = b
+				r.Body = ioutil.NopCloser(bytes.NewReader(bodyBytes))
+			}
+
+			resp, err := s.Do(r)
+			if err != nil || resp.StatusCode != http.StatusConflict {
+				return resp, err
+			}
+
+			b, readErr := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp.Body = ioutil.NopCloser(bytes.NewReader(b)) // replace body with in-memory reader
+			if readErr != nil {
+				return resp, err
+			}
+
+			se, decodeErr := decodeARMError(b)
+			if decodeErr != nil || se == nil || se.Code != missingSubscriptionRegistrationCode {
+				return resp, err
+			}
+
+			namespace := resourceProviderNamespace(se)
+			if namespace == "" {
+				return resp, err
+			}
+
+			if regErr := registerProvider(s, client, r, namespace); regErr != nil {
+				return resp, regErr
+			}
+
+			if r.GetBody != nil {
+				body, getErr := r.GetBody()
+				if getErr != nil {
+					return resp, getErr
+				}
+				r.Body = body
+			} else if bodyBytes != nil {
+				r.Body = ioutil.NopCloser(bytes.NewReader(bodyBytes))
+			}
+			return s.Do(r)
+		})
+	}
+}
+
+// registerProvider issues the register call for namespace against the subscription
+// found in r's URL, then polls until the provider reports itself Registered.
+func registerProvider(sender autorest.Sender, client autorest.Client, r *http.Request, namespace string) error {
+	m := subscriptionIDRE.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		return fmt.Errorf("autorest/azure: could not determine subscription id from request URL %s to register provider %s", r.URL, namespace)
+	}
+	providerURL := fmt.Sprintf("%s://%s/subscriptions/%s/providers/%s", r.URL.Scheme, r.URL.Host, m[1], namespace)
+
+	registerReq, err := autorest.Prepare(&http.Request{},
+		autorest.AsPost(),
+		autorest.WithBaseURL(providerURL+"/register"),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": providerAPIVersion}))
+	if err != nil {
+		return err
+	}
+	registerReq.Header = cloneHeader(r.Header)
+
+	resp, err := sender.Do(registerReq)
+	if err != nil {
+		return err
+	}
+	autorest.Respond(resp, client.ByInspecting(), autorest.ByClosing())
+	if !autorest.ResponseHasStatusCode(resp, http.StatusOK) {
+		return fmt.Errorf("autorest/azure: failed to register resource provider %s: registration request returned status %d", namespace, resp.StatusCode)
+	}
+
+	return pollProviderRegistration(sender, client, providerURL, r.Header, r.Cancel)
+}
+
+// pollProviderRegistration polls providerURL until the provider's registrationState is
+// Registered or the client's configured polling duration elapses. header is cloned onto
+// each poll request so it carries the same Authorization (and other) headers as the
+// request that triggered registration.
+func pollProviderRegistration(sender autorest.Sender, client autorest.Client, providerURL string, header http.Header, cancel <-chan struct{}) error {
+	timeout := client.PollingDuration
+	if timeout <= 0 {
+		timeout = defaultRegistrationPollingTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		req, err := autorest.Prepare(&http.Request{},
+			autorest.AsGet(),
+			autorest.WithBaseURL(providerURL),
+			autorest.WithQueryParameters(map[string]interface{}{"api-version": providerAPIVersion}))
+		if err != nil {
+			return err
+		}
+		req.Header = cloneHeader(header)
+
+		resp, err := sender.Do(req)
+		if err != nil {
+			return err
+		}
+
+		var pr struct {
+			RegistrationState string `json:"registrationState"`
+		}
+		b, decodeErr := autorest.CopyAndDecode(autorest.EncodedAsJSON, resp.Body, &pr)
+		resp.Body = ioutil.NopCloser(&b)
+		autorest.Respond(resp, client.ByInspecting(), autorest.ByClosing())
+		if decodeErr != nil {
+			return fmt.Errorf("autorest/azure: could not decode provider registration state: %q error: %v", b.String(), decodeErr)
+		}
+
+		if strings.EqualFold(pr.RegistrationState, "Registered") {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("autorest/azure: timed out waiting for provider registration; last registrationState was %q", pr.RegistrationState)
+		}
+
+		delay := autorest.GetPollingDelay(resp, defaultRegistrationPollingDelay)
+		if err := autorest.DelayForBackoff(delay, 1, cancel); err != nil {
+			return err
+		}
+	}
+}
+
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, v := range h {
+		v2 := make([]string, len(v))
+		copy(v2, v)
+		h2[k] = v2
+	}
+	return h2
+}
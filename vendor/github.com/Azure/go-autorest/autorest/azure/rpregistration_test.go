@@ -0,0 +1,139 @@
+package azure
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+const registrationWantAuth = "Bearer original-token"
+
+// newRegistrationServer simulates a subscription whose first PUT to the resource fails
+// with MissingSubscriptionRegistration, then succeeds once the namespace is registered.
+// It fails the test if the register or poll requests are missing the Authorization header
+// carried by the original request.
+func newRegistrationServer(t *testing.T, wantBody string) (*httptest.Server, *int32) {
+	t.Helper()
+	var resourceCalls int32
+	var registered int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/subscriptions/sub1/resourceGroups/rg1/providers/Microsoft.Foo/widgets/w1", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if wantBody != "" && string(b) != wantBody {
+			t.Errorf("resource request body = %q, want %q", b, wantBody)
+		}
+		if n := atomic.AddInt32(&resourceCalls, 1); n == 1 {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(RequestError{
+				ServiceError: &ServiceError{
+					Code:    missingSubscriptionRegistrationCode,
+					Message: "The subscription is not registered to use namespace 'Microsoft.Foo'",
+				},
+			})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/subscriptions/sub1/providers/Microsoft.Foo/register", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != registrationWantAuth {
+			t.Errorf("register request missing Authorization header: got %q want %q", got, registrationWantAuth)
+		}
+		atomic.StoreInt32(&registered, 1)
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/subscriptions/sub1/providers/Microsoft.Foo", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != registrationWantAuth {
+			t.Errorf("poll request missing Authorization header: got %q want %q", got, registrationWantAuth)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"registrationState": "Registered"})
+	})
+
+	return httptest.NewServer(mux), &resourceCalls
+}
+
+func TestDoRetryWithRegistrationRegistersAndRetries(t *testing.T) {
+	const body = `{"location":"westus"}`
+	srv, resourceCalls := newRegistrationServer(t, body)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodPut, srv.URL+"/subscriptions/sub1/resourceGroups/rg1/providers/Microsoft.Foo/widgets/w1", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Authorization", registrationWantAuth)
+
+	client := autorest.Client{}
+	send := DoRetryWithRegistration(client)(autorest.SenderFunc(srv.Client().Do))
+
+	resp, err := send.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("final status = %d, want 200", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(resourceCalls); got != 2 {
+		t.Errorf("resource was called %d times, want 2 (initial 409 + retry)", got)
+	}
+}
+
+// TestDoRetryWithRegistrationFallsBackToBufferingWithoutGetBody exercises the manual
+// buffering path for a request body with no GetBody (e.g. built directly from an
+// io.Reader that isn't one of the types net/http knows how to rewind).
+func TestDoRetryWithRegistrationFallsBackToBufferingWithoutGetBody(t *testing.T) {
+	const body = `{"location":"westus"}`
+	srv, resourceCalls := newRegistrationServer(t, body)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodPut, srv.URL+"/subscriptions/sub1/resourceGroups/rg1/providers/Microsoft.Foo/widgets/w1", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Authorization", registrationWantAuth)
+	req.GetBody = nil // force the no-GetBody fallback even though strings.Reader normally sets one
+
+	client := autorest.Client{}
+	send := DoRetryWithRegistration(client)(autorest.SenderFunc(srv.Client().Do))
+
+	resp, err := send.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("final status = %d, want 200", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(resourceCalls); got != 2 {
+		t.Errorf("resource was called %d times, want 2 (initial 409 + retry)", got)
+	}
+}
+
+func TestDoRetryWithRegistrationPassesThroughNonConflict(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/subscriptions/sub1/resourceGroups/rg1/providers/Microsoft.Foo/widgets/w1", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+
+	client := autorest.Client{}
+	send := DoRetryWithRegistration(client)(autorest.SenderFunc(srv.Client().Do))
+
+	resp, err := send.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,399 @@
+package azure
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// defaultRefreshWithin is how long before a Token's expiry EnsureFresh will refresh it.
+const defaultRefreshWithin = 5 * time.Minute
+
+// defaultMSIEndpoint is the Azure Instance Metadata Service endpoint used by
+// NewServicePrincipalTokenFromMSI when no endpoint is given.
+const defaultMSIEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// Token encapsulates the access token returned by an Azure Active Directory token
+// endpoint. Numeric fields are strings because that is how AAD encodes them on the wire.
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+
+	ExpiresIn string `json:"expires_in"`
+	ExpiresOn string `json:"expires_on"`
+	NotBefore string `json:"not_before"`
+
+	Resource string `json:"resource"`
+	Type     string `json:"token_type"`
+}
+
+// Expires returns the time at which the Token expires.
+func (t Token) Expires() time.Time {
+	s, err := strconv.ParseInt(t.ExpiresOn, 10, 64)
+	if err != nil {
+		// a Token that can't tell us when it expires is treated as already expired
+		return time.Time{}
+	}
+	return time.Unix(s, 0).UTC()
+}
+
+// WillExpireIn returns true if the Token's remaining lifetime is less than or equal to d.
+func (t Token) WillExpireIn(d time.Duration) bool {
+	return !t.Expires().After(time.Now().Add(d))
+}
+
+// IsExpired returns true if the Token is expired.
+func (t Token) IsExpired() bool {
+	return t.WillExpireIn(0)
+}
+
+// SetAuthHeader sets the Authorization header of r to "Bearer <access token>".
+func (t *Token) SetAuthHeader(r *http.Request) {
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", t.AccessToken))
+}
+
+// OAuthConfig represents the set of endpoints needed to perform OAuth operations for a
+// tenant against a particular Environment's Active Directory.
+type OAuthConfig struct {
+	AuthorityEndpoint  url.URL
+	AuthorizeEndpoint  url.URL
+	TokenEndpoint      url.URL
+	DeviceCodeEndpoint url.URL
+}
+
+// NewOAuthConfig returns the OAuth endpoints for tenantID within the Active Directory
+// named by activeDirectoryEndpoint (typically an Environment's ActiveDirectoryEndpoint).
+// tenantID may also be "common" for multi-tenant applications.
+func NewOAuthConfig(activeDirectoryEndpoint, tenantID string) (*OAuthConfig, error) {
+	const apiVersion = "1.0"
+
+	api, err := url.Parse(activeDirectoryEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("autorest/azure: invalid ActiveDirectoryEndpoint %q: %v", activeDirectoryEndpoint, err)
+	}
+	authorityURL, err := api.Parse(tenantID + "/")
+	if err != nil {
+		return nil, err
+	}
+	authorizeURL, err := authorityURL.Parse(fmt.Sprintf("oauth2/authorize?api-version=%s", apiVersion))
+	if err != nil {
+		return nil, err
+	}
+	tokenURL, err := authorityURL.Parse(fmt.Sprintf("oauth2/token?api-version=%s", apiVersion))
+	if err != nil {
+		return nil, err
+	}
+	deviceCodeURL, err := authorityURL.Parse(fmt.Sprintf("oauth2/devicecode?api-version=%s", apiVersion))
+	if err != nil {
+		return nil, err
+	}
+
+	return &OAuthConfig{
+		AuthorityEndpoint:  *authorityURL,
+		AuthorizeEndpoint:  *authorizeURL,
+		TokenEndpoint:      *tokenURL,
+		DeviceCodeEndpoint: *deviceCodeURL,
+	}, nil
+}
+
+// servicePrincipalSecret is implemented by the different kinds of secret material a
+// ServicePrincipalToken can use to obtain and refresh a Token.
+type servicePrincipalSecret interface {
+	SetAuthenticationValues(spt *ServicePrincipalToken, v *url.Values) error
+}
+
+// servicePrincipalClientSecret authenticates with the classic client-secret ("app key")
+// client-credentials flow.
+type servicePrincipalClientSecret struct {
+	ClientSecret string
+}
+
+func (s *servicePrincipalClientSecret) SetAuthenticationValues(spt *ServicePrincipalToken, v *url.Values) error {
+	v.Set("client_secret", s.ClientSecret)
+	return nil
+}
+
+// servicePrincipalCertificateSecret authenticates by signing a JWT client assertion with
+// an X.509 certificate and its private key.
+type servicePrincipalCertificateSecret struct {
+	Certificate *x509.Certificate
+	PrivateKey  *rsa.PrivateKey
+}
+
+func (s *servicePrincipalCertificateSecret) SetAuthenticationValues(spt *ServicePrincipalToken, v *url.Values) error {
+	assertion, err := s.signJWT(spt)
+	if err != nil {
+		return err
+	}
+	v.Set("client_assertion", assertion)
+	v.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	return nil
+}
+
+func (s *servicePrincipalCertificateSecret) signJWT(spt *ServicePrincipalToken) (string, error) {
+	hasher := sha1.New()
+	if _, err := hasher.Write(s.Certificate.Raw); err != nil {
+		return "", fmt.Errorf("autorest/azure: failed to hash certificate for JWT client assertion: %v", err)
+	}
+
+	jti := make([]byte, 20)
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("autorest/azure: failed to generate JWT client assertion id: %v", err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"aud": spt.oauthConfig.TokenEndpoint.String(),
+		"iss": spt.clientID,
+		"sub": spt.clientID,
+		"jti": base64.URLEncoding.EncodeToString(jti),
+		"nbf": time.Now().Unix(),
+		"exp": time.Now().Add(24 * time.Hour).Unix(),
+	})
+	token.Header["x5t"] = base64.RawURLEncoding.EncodeToString(hasher.Sum(nil))
+
+	return token.SignedString(s.PrivateKey)
+}
+
+// servicePrincipalRefreshSecret authenticates solely with a refresh token, as when
+// resuming a ServicePrincipalToken previously persisted with SaveToken.
+type servicePrincipalRefreshSecret struct{}
+
+func (s *servicePrincipalRefreshSecret) SetAuthenticationValues(spt *ServicePrincipalToken, v *url.Values) error {
+	return nil
+}
+
+// servicePrincipalMSISecret marks a ServicePrincipalToken that is refreshed from the
+// virtual machine's Managed Service Identity endpoint rather than Azure Active Directory.
+type servicePrincipalMSISecret struct{}
+
+func (s *servicePrincipalMSISecret) SetAuthenticationValues(spt *ServicePrincipalToken, v *url.Values) error {
+	return nil
+}
+
+// ServicePrincipalToken wraps a Token along with whatever secret material is needed to
+// refresh it, automatically refreshing on EnsureFresh when the Token is within its
+// refresh window of expiring.
+type ServicePrincipalToken struct {
+	Token
+
+	// mu guards Token: a ServicePrincipalToken is normally shared across concurrent
+	// requests through a single autorest.Client, each calling EnsureFresh via
+	// WithAuthorization.
+	mu sync.RWMutex
+
+	secret      servicePrincipalSecret
+	oauthConfig OAuthConfig
+	clientID    string
+	resource    string
+
+	autoRefresh   bool
+	refreshWithin time.Duration
+
+	msiEndpoint string
+
+	sender autorest.Sender
+}
+
+func newServicePrincipalToken(oauthConfig OAuthConfig, clientID, resource string, secret servicePrincipalSecret) *ServicePrincipalToken {
+	return &ServicePrincipalToken{
+		oauthConfig:   oauthConfig,
+		clientID:      clientID,
+		resource:      resource,
+		secret:        secret,
+		autoRefresh:   true,
+		refreshWithin: defaultRefreshWithin,
+		sender:        &http.Client{},
+	}
+}
+
+// SetSender sets the autorest.Sender used to acquire and refresh tokens, replacing the
+// default http.Client. Useful for routing through a proxy or for testing.
+func (spt *ServicePrincipalToken) SetSender(s autorest.Sender) {
+	spt.sender = s
+}
+
+// NewServicePrincipalToken creates a ServicePrincipalToken that authenticates clientID
+// against resource using the OAuth2 client-credentials flow and the given client secret.
+func NewServicePrincipalToken(oauthConfig OAuthConfig, clientID string, secret string, resource string) (*ServicePrincipalToken, error) {
+	if clientID == "" || secret == "" || resource == "" {
+		return nil, errors.New("autorest/azure: clientID, secret, and resource are required to create a ServicePrincipalToken")
+	}
+	return newServicePrincipalToken(oauthConfig, clientID, resource, &servicePrincipalClientSecret{ClientSecret: secret}), nil
+}
+
+// NewServicePrincipalTokenFromCertificate creates a ServicePrincipalToken that
+// authenticates clientID against resource by signing a JWT client assertion with
+// certificate and its private key.
+func NewServicePrincipalTokenFromCertificate(oauthConfig OAuthConfig, clientID string, certificate *x509.Certificate, privateKey *rsa.PrivateKey, resource string) (*ServicePrincipalToken, error) {
+	if clientID == "" || certificate == nil || privateKey == nil || resource == "" {
+		return nil, errors.New("autorest/azure: clientID, certificate, privateKey, and resource are required to create a ServicePrincipalToken")
+	}
+	return newServicePrincipalToken(oauthConfig, clientID, resource, &servicePrincipalCertificateSecret{
+		Certificate: certificate,
+		PrivateKey:  privateKey,
+	}), nil
+}
+
+// NewServicePrincipalTokenFromManualToken creates a ServicePrincipalToken from a
+// previously acquired Token (for example one restored with LoadToken), refreshing it with
+// the refresh_token grant once it expires.
+func NewServicePrincipalTokenFromManualToken(oauthConfig OAuthConfig, clientID string, resource string, token Token) (*ServicePrincipalToken, error) {
+	if clientID == "" || resource == "" {
+		return nil, errors.New("autorest/azure: clientID and resource are required to create a ServicePrincipalToken from a manual token")
+	}
+	if token.RefreshToken == "" {
+		return nil, errors.New("autorest/azure: a refresh token is required to create a ServicePrincipalToken from a manual token")
+	}
+	spt := newServicePrincipalToken(oauthConfig, clientID, resource, &servicePrincipalRefreshSecret{})
+	spt.Token = token
+	return spt, nil
+}
+
+// NewServicePrincipalTokenFromMSI creates a ServicePrincipalToken that acquires and
+// refreshes tokens from the virtual machine's Managed Service Identity endpoint instead of
+// Azure Active Directory. If msiEndpoint is empty, the standard Instance Metadata Service
+// endpoint is used.
+func NewServicePrincipalTokenFromMSI(msiEndpoint, resource string) (*ServicePrincipalToken, error) {
+	if resource == "" {
+		return nil, errors.New("autorest/azure: resource is required to create a ServicePrincipalToken from MSI")
+	}
+	if msiEndpoint == "" {
+		msiEndpoint = defaultMSIEndpoint
+	}
+	spt := newServicePrincipalToken(OAuthConfig{}, "", resource, &servicePrincipalMSISecret{})
+	spt.msiEndpoint = msiEndpoint
+	return spt, nil
+}
+
+// EnsureFresh refreshes the token if it will expire within its configured refresh window
+// (5 minutes by default).
+func (spt *ServicePrincipalToken) EnsureFresh() error {
+	spt.mu.RLock()
+	expiring := spt.autoRefresh && spt.WillExpireIn(spt.refreshWithin)
+	spt.mu.RUnlock()
+	if expiring {
+		return spt.Refresh()
+	}
+	return nil
+}
+
+// Refresh unconditionally obtains a fresh Token for the service principal, using the MSI
+// endpoint if this token was created with NewServicePrincipalTokenFromMSI, or the
+// configured Active Directory token endpoint otherwise.
+func (spt *ServicePrincipalToken) Refresh() error {
+	spt.mu.Lock()
+	defer spt.mu.Unlock()
+	if spt.msiEndpoint != "" {
+		return spt.refreshFromMSI()
+	}
+	return spt.refreshFromADAL()
+}
+
+// refreshFromADAL requests a fresh Token and stores it. Callers must hold spt.mu for writing.
+func (spt *ServicePrincipalToken) refreshFromADAL() error {
+	v := url.Values{}
+	v.Set("client_id", spt.clientID)
+	v.Set("resource", spt.resource)
+
+	if spt.RefreshToken != "" {
+		v.Set("grant_type", "refresh_token")
+		v.Set("refresh_token", spt.RefreshToken)
+	} else {
+		v.Set("grant_type", "client_credentials")
+	}
+
+	if err := spt.secret.SetAuthenticationValues(spt, &v); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, spt.oauthConfig.TokenEndpoint.String(), strings.NewReader(v.Encode()))
+	if err != nil {
+		return fmt.Errorf("autorest/azure: failed to build token refresh request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	token, err := spt.requestToken(req, "refresh token")
+	if err != nil {
+		return err
+	}
+	spt.Token = *token
+	return nil
+}
+
+// refreshFromMSI requests a fresh Token and stores it. Callers must hold spt.mu for writing.
+func (spt *ServicePrincipalToken) refreshFromMSI() error {
+	req, err := http.NewRequest(http.MethodGet, spt.msiEndpoint, nil)
+	if err != nil {
+		return fmt.Errorf("autorest/azure: failed to build MSI token request: %v", err)
+	}
+	req.Header.Set("Metadata", "true")
+
+	q := req.URL.Query()
+	q.Set("resource", spt.resource)
+	q.Set("api-version", "2018-02-01")
+	req.URL.RawQuery = q.Encode()
+
+	token, err := spt.requestToken(req, "MSI token")
+	if err != nil {
+		return err
+	}
+	spt.Token = *token
+	return nil
+}
+
+func (spt *ServicePrincipalToken) requestToken(req *http.Request, what string) (*Token, error) {
+	resp, err := spt.sender.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("autorest/azure: failed to acquire %s: %v", what, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("autorest/azure: failed to read %s response: %v", what, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("autorest/azure: %s request failed with status %d: %s", what, resp.StatusCode, string(b))
+	}
+
+	var token Token
+	if err := json.Unmarshal(b, &token); err != nil {
+		return nil, fmt.Errorf("autorest/azure: failed to decode %s response: %q error: %v", what, string(b), err)
+	}
+	return &token, nil
+}
+
+// WithAuthorization returns a PrepareDecorator that calls EnsureFresh before adding the
+// Token's access token to the request's Authorization header.
+func (spt *ServicePrincipalToken) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err != nil {
+				return r, err
+			}
+			if err := spt.EnsureFresh(); err != nil {
+				return r, fmt.Errorf("autorest/azure: failed to refresh Service Principal Token for request to %s: %v", r.URL, err)
+			}
+			spt.mu.RLock()
+			spt.SetAuthHeader(r)
+			spt.mu.RUnlock()
+			return r, nil
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package azure
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func newTestOAuthConfig(t *testing.T, srv *httptest.Server) OAuthConfig {
+	t.Helper()
+	cfg, err := NewOAuthConfig(srv.URL, "tenant1")
+	if err != nil {
+		t.Fatalf("NewOAuthConfig: %v", err)
+	}
+	return *cfg
+}
+
+func TestNewServicePrincipalTokenRequiresFields(t *testing.T) {
+	if _, err := NewServicePrincipalToken(OAuthConfig{}, "", "secret", "resource"); err == nil {
+		t.Error("NewServicePrincipalToken with no clientID returned no error, want one")
+	}
+}
+
+func TestServicePrincipalTokenRefreshClientCredentials(t *testing.T) {
+	var gotGrantType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("ParseForm: %v", err)
+		}
+		gotGrantType = r.Form.Get("grant_type")
+		if got := r.Form.Get("client_secret"); got != "shh" {
+			t.Errorf("client_secret = %q, want shh", got)
+		}
+		fmt.Fprint(w, `{"access_token":"at1","token_type":"Bearer","expires_in":"3600","expires_on":"9999999999"}`)
+	}))
+	defer srv.Close()
+
+	cfg := newTestOAuthConfig(t, srv)
+	spt, err := NewServicePrincipalToken(cfg, "client1", "shh", "resource")
+	if err != nil {
+		t.Fatalf("NewServicePrincipalToken: %v", err)
+	}
+	spt.SetSender(srv.Client())
+
+	if err := spt.Refresh(); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if spt.AccessToken != "at1" {
+		t.Errorf("AccessToken = %q, want at1", spt.AccessToken)
+	}
+	if gotGrantType != "client_credentials" {
+		t.Errorf("grant_type = %q, want client_credentials", gotGrantType)
+	}
+}
+
+func TestServicePrincipalTokenEnsureFreshSkipsUnexpiredToken(t *testing.T) {
+	var refreshes int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&refreshes, 1)
+		fmt.Fprint(w, `{"access_token":"at2","token_type":"Bearer","expires_in":"3600","expires_on":"9999999999"}`)
+	}))
+	defer srv.Close()
+
+	cfg := newTestOAuthConfig(t, srv)
+	spt, err := NewServicePrincipalToken(cfg, "client1", "shh", "resource")
+	if err != nil {
+		t.Fatalf("NewServicePrincipalToken: %v", err)
+	}
+	spt.SetSender(srv.Client())
+	spt.Token = Token{AccessToken: "still-fresh", ExpiresOn: fmt.Sprintf("%d", time.Now().Add(time.Hour).Unix())}
+
+	if err := spt.EnsureFresh(); err != nil {
+		t.Fatalf("EnsureFresh: %v", err)
+	}
+	if spt.AccessToken != "still-fresh" {
+		t.Errorf("AccessToken = %q, want still-fresh (no refresh should have happened)", spt.AccessToken)
+	}
+	if got := atomic.LoadInt32(&refreshes); got != 0 {
+		t.Errorf("server was refreshed %d times, want 0", got)
+	}
+}
+
+func TestServicePrincipalTokenEnsureFreshRefreshesExpiringToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token":"at3","token_type":"Bearer","expires_in":"3600","expires_on":"9999999999"}`)
+	}))
+	defer srv.Close()
+
+	cfg := newTestOAuthConfig(t, srv)
+	spt, err := NewServicePrincipalToken(cfg, "client1", "shh", "resource")
+	if err != nil {
+		t.Fatalf("NewServicePrincipalToken: %v", err)
+	}
+	spt.SetSender(srv.Client())
+	spt.Token = Token{AccessToken: "about-to-expire", ExpiresOn: fmt.Sprintf("%d", time.Now().Unix())}
+
+	if err := spt.EnsureFresh(); err != nil {
+		t.Fatalf("EnsureFresh: %v", err)
+	}
+	if spt.AccessToken != "at3" {
+		t.Errorf("AccessToken = %q, want at3 (EnsureFresh should have refreshed)", spt.AccessToken)
+	}
+}
+
+// TestServicePrincipalTokenConcurrentEnsureFresh drives many goroutines through
+// EnsureFresh/WithAuthorization concurrently on a single shared ServicePrincipalToken,
+// the way a single autorest.Client shared across API calls does. It exists to catch the
+// data race around Token that spt.mu guards against; it is most useful run with -race.
+func TestServicePrincipalTokenConcurrentEnsureFresh(t *testing.T) {
+	var counter int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&counter, 1)
+		fmt.Fprintf(w, `{"access_token":"at-%d","token_type":"Bearer","expires_in":"3600","expires_on":"9999999999"}`, n)
+	}))
+	defer srv.Close()
+
+	cfg := newTestOAuthConfig(t, srv)
+	spt, err := NewServicePrincipalToken(cfg, "client1", "shh", "resource")
+	if err != nil {
+		t.Fatalf("NewServicePrincipalToken: %v", err)
+	}
+	spt.SetSender(srv.Client())
+	spt.Token = Token{AccessToken: "expired", ExpiresOn: "0"}
+
+	prepare := spt.WithAuthorization()
+	noop := autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) { return r, nil })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, "https://management.azure.com/", nil)
+			if _, err := prepare(noop).Prepare(req); err != nil {
+				t.Errorf("Prepare: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if spt.AccessToken == "" || spt.AccessToken == "expired" {
+		t.Errorf("AccessToken = %q, want a refreshed token", spt.AccessToken)
+	}
+}
+
+func TestSignJWTUsesUnpaddedBase64ForThumbprint(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+
+	spt := &ServicePrincipalToken{
+		clientID: "client1",
+		oauthConfig: OAuthConfig{
+			TokenEndpoint: url.URL{Scheme: "https", Host: "login.microsoftonline.com", Path: "/tenant1/oauth2/token"},
+		},
+	}
+	secret := &servicePrincipalCertificateSecret{Certificate: cert, PrivateKey: key}
+
+	jwt, err := secret.signJWT(spt)
+	if err != nil {
+		t.Fatalf("signJWT: %v", err)
+	}
+
+	headerB64 := strings.Split(jwt, ".")[0]
+	headerJSON, err := base64.RawURLEncoding.DecodeString(headerB64)
+	if err != nil {
+		t.Fatalf("decoding JWT header: %v", err)
+	}
+	if strings.Contains(string(headerJSON), "=") {
+		t.Fatalf("x5t appears padded in JWT header: %s", headerJSON)
+	}
+}